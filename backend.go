@@ -0,0 +1,209 @@
+package cascadestore
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "google.golang.org/appengine/datastore"
+    "google.golang.org/appengine/memcache"
+)
+
+// Backend is a single layer of CascadeStore's cascade: somewhere a
+// serialized session can be read from and written to. CascadeStore holds
+// an ordered []Backend and walks it front-to-back on read, returning the
+// first hit, and writes/deletes to all of them, so a new kind of storage
+// (filesystem, Spanner, Firestore, ...) is a new Backend implementation,
+// never a change to CascadeStore itself.
+type Backend interface {
+    // Get returns the raw serialized session stored at key, or
+    // found == false if it's absent or has expired.
+    Get(ctx context.Context, key string) (value []byte, found bool, err error)
+    // Set stores value at key with the given ttl.
+    Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+    // Delete removes key, if present. Deleting an absent key is not an error.
+    Delete(ctx context.Context, key string) error
+}
+
+// remainingTTLer is implemented by backends that can report how much TTL a
+// key has left without a full read, so sliding expiration (see touch.go)
+// can skip a redundant write when there's still plenty of time on the
+// clock. Backends that don't implement it are always touched on a
+// sliding-expiration read.
+type remainingTTLer interface {
+    RemainingTTL(ctx context.Context, key string) (ttl time.Duration, found bool, err error)
+}
+
+// backendsFromBitmask builds the []Backend equivalent of NewCascadeStore's
+// historical RequestBackend/MemcacheBackend/DatastoreBackend/
+// DatastoreWriteBehindBackend bitmask, in the same cascade order.
+func backendsFromBitmask(backendTypes int, queueName string) []Backend {
+    var backends []Backend
+
+    if backendTypes&RequestBackend > 0 {
+        backends = append(backends, requestBackend{})
+    }
+    if backendTypes&MemcacheBackend > 0 {
+        backends = append(backends, memcacheBackend{})
+    }
+    if backendTypes&DatastoreBackend > 0 {
+        backends = append(backends, datastoreBackend{})
+    }
+    if backendTypes&DatastoreWriteBehindBackend > 0 {
+        backends = append(backends, datastoreBackend{writeBehind: true, queueName: queueName, pending: newPendingWrites()})
+    }
+
+    return backends
+}
+
+// requestContextValueKeyType is the context key under which save/load/
+// delete stash the *http.Request they were called with, so requestBackend
+// (a Backend, and therefore only given a context.Context) can still reach
+// it.
+type requestContextValueKeyType struct{}
+
+func contextWithRequest(ctx context.Context, r *http.Request) context.Context {
+    return context.WithValue(ctx, requestContextValueKeyType{}, r)
+}
+
+func requestFromContext(ctx context.Context) (*http.Request, bool) {
+    r, ok := ctx.Value(requestContextValueKeyType{}).(*http.Request)
+    return r, ok
+}
+
+// requestBackend caches a session for the lifetime of a single request. It
+// won't see updates made by concurrent requests from the same
+// user/browser, so it's only useful to avoid re-reading the other backends
+// multiple times within one request. See reqcontext.go for where the cache
+// itself lives.
+type requestBackend struct{}
+
+func (requestBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    r, ok := requestFromContext(ctx)
+    if !ok {
+        return nil, false, nil
+    }
+
+    item, ok := getRequestItem(r, key)
+    if !ok {
+        return nil, false, nil
+    }
+    if time.Now().After(item.ExpiresAt) {
+        deleteRequestItem(r, key)
+        return nil, false, nil
+    }
+    return item.Value, true, nil
+}
+
+func (requestBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    r, ok := requestFromContext(ctx)
+    if !ok {
+        return nil
+    }
+    setRequestItem(r, key, requestItem{Value: value, ExpiresAt: time.Now().Add(ttl)})
+    return nil
+}
+
+func (requestBackend) Delete(ctx context.Context, key string) error {
+    r, ok := requestFromContext(ctx)
+    if !ok {
+        return nil
+    }
+    deleteRequestItem(r, key)
+    return nil
+}
+
+// memcacheBackend stores sessions in App Engine Memcache, relying on its
+// native item expiration for TTL.
+type memcacheBackend struct{}
+
+func (memcacheBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    item, err := memcache.Get(ctx, key)
+    if err != nil {
+        if err == memcache.ErrCacheMiss {
+            return nil, false, nil
+        }
+        return nil, false, err
+    }
+    return item.Value, true, nil
+}
+
+func (memcacheBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    return memcache.Set(ctx, &memcache.Item{Key: key, Value: value, Expiration: ttl})
+}
+
+func (memcacheBackend) Delete(ctx context.Context, key string) error {
+    if err := memcache.Delete(ctx, key); err != nil && err != memcache.ErrCacheMiss {
+        return err
+    }
+    return nil
+}
+
+// datastoreBackend stores sessions as sessionKind entities, tracking
+// expiration explicitly since Datastore has no native TTL. When writeBehind
+// is set, Set enqueues the write on queueName instead of performing it
+// inline (see writebehind.go), falling back to a synchronous Put if the
+// enqueue fails. pending tracks the same write so CascadeStore.Flush can
+// apply it directly without depending on the taskqueue actually running it.
+type datastoreBackend struct {
+    writeBehind bool
+    queueName   string
+    pending     *pendingWrites
+}
+
+func (b datastoreBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    k := datastore.NewKey(ctx, "Session", key, 0, nil)
+    s := &sessionKind{}
+    if err := datastore.Get(ctx, k, s); err != nil {
+        if err == datastore.ErrNoSuchEntity {
+            return nil, false, nil
+        }
+        return nil, false, err
+    }
+
+    if !time.Now().Before(s.ExpiresAt) {
+        if err := datastore.Delete(ctx, k); err != nil {
+            return nil, false, err
+        }
+        return nil, false, nil
+    }
+
+    return s.Value, true, nil
+}
+
+func (b datastoreBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    expiresAt := time.Now().Add(ttl)
+
+    if b.writeBehind {
+        if err := enqueueWriteBehind(ctx, b.queueName, key, value, expiresAt); err == nil {
+            if b.pending != nil {
+                b.pending.add(writeBehindPayload{Key: key, Value: value, ExpiresAt: expiresAt})
+            }
+            return nil
+        }
+        // Enqueue failed (e.g. queue unreachable); fall through and write
+        // synchronously instead of losing the session entirely.
+    }
+
+    s := &sessionKind{Value: value, ExpiresAt: expiresAt}
+    k := datastore.NewKey(ctx, "Session", key, 0, nil)
+    _, err := datastore.Put(ctx, k, s)
+    return err
+}
+
+func (b datastoreBackend) Delete(ctx context.Context, key string) error {
+    k := datastore.NewKey(ctx, "Session", key, 0, nil)
+    return datastore.Delete(ctx, k)
+}
+
+func (b datastoreBackend) RemainingTTL(ctx context.Context, key string) (time.Duration, bool, error) {
+    k := datastore.NewKey(ctx, "Session", key, 0, nil)
+    s := &sessionKind{}
+    if err := datastore.Get(ctx, k, s); err != nil {
+        if err == datastore.ErrNoSuchEntity {
+            return 0, false, nil
+        }
+        return 0, false, err
+    }
+    return s.ExpiresAt.Sub(time.Now()), true, nil
+}
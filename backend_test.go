@@ -0,0 +1,55 @@
+package cascadestore
+
+import "testing"
+
+func TestBackendsFromBitmaskOrderAndTypes(t *testing.T) {
+    backends := backendsFromBitmask(AllBackends, DefaultQueueName)
+
+    if len(backends) != 3 {
+        t.Fatalf("len(backends) = %d, want 3", len(backends))
+    }
+    if _, ok := backends[0].(requestBackend); !ok {
+        t.Errorf("backends[0] = %T, want requestBackend", backends[0])
+    }
+    if _, ok := backends[1].(memcacheBackend); !ok {
+        t.Errorf("backends[1] = %T, want memcacheBackend", backends[1])
+    }
+    if db, ok := backends[2].(datastoreBackend); !ok {
+        t.Errorf("backends[2] = %T, want datastoreBackend", backends[2])
+    } else if db.writeBehind {
+        t.Error("backends[2].writeBehind = true, want false for plain DatastoreBackend")
+    }
+}
+
+func TestBackendsFromBitmaskWriteBehindHasPending(t *testing.T) {
+    backends := backendsFromBitmask(DatastoreWriteBehindBackend, "my-queue")
+
+    if len(backends) != 1 {
+        t.Fatalf("len(backends) = %d, want 1", len(backends))
+    }
+    db, ok := backends[0].(datastoreBackend)
+    if !ok {
+        t.Fatalf("backends[0] = %T, want datastoreBackend", backends[0])
+    }
+    if !db.writeBehind {
+        t.Error("writeBehind = false, want true")
+    }
+    if db.queueName != "my-queue" {
+        t.Errorf("queueName = %q, want %q", db.queueName, "my-queue")
+    }
+    if db.pending == nil {
+        t.Error("pending = nil, want a *pendingWrites so Flush has something to drain")
+    }
+}
+
+func TestNewCascadeStoreWiresPendingWrites(t *testing.T) {
+    cs := NewCascadeStore(WriteBehindBackends)
+    if cs.pendingWrites == nil {
+        t.Fatal("cs.pendingWrites = nil, want it wired to the write-behind backend's pending tracker")
+    }
+
+    csNoWriteBehind := NewCascadeStore(AllBackends)
+    if csNoWriteBehind.pendingWrites != nil {
+        t.Error("cs.pendingWrites should stay nil without a DatastoreWriteBehindBackend configured")
+    }
+}
@@ -0,0 +1,110 @@
+package cascadestore
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "time"
+
+    "google.golang.org/appengine"
+    "google.golang.org/appengine/datastore"
+    "google.golang.org/appengine/log"
+)
+
+// GCPath is the internal handler path for mounting CascadeStore.GCHandler()
+// behind App Engine cron.
+const GCPath = "/_ah/cascadestore/gc"
+
+// DefaultGCBatchSize is the number of keys deleted per datastore.DeleteMulti
+// call during GC.
+const DefaultGCBatchSize = 500
+
+var (
+    // NotCronError is returned by GCHandler when a request arrives without
+    // the App Engine cron header.
+    NotCronError = errors.New("cascadestore: request did not come from App Engine Cron")
+)
+
+// GCBatchSize controls how many keys GC deletes per datastore.DeleteMulti.
+// Zero means DefaultGCBatchSize.
+func (cs *CascadeStore) GCBatchSize() int {
+    if cs.gcBatchSize <= 0 {
+        return DefaultGCBatchSize
+    }
+    return cs.gcBatchSize
+}
+
+// SetGCBatchSize overrides the number of keys GC deletes per
+// datastore.DeleteMulti. Values <= 0 restore DefaultGCBatchSize.
+func (cs *CascadeStore) SetGCBatchSize(n int) {
+    cs.gcBatchSize = n
+}
+
+// GC deletes expired Session entities from Datastore. CascadeStore.load only
+// removes an entity when it happens to be read after expiry, so rows from
+// sessions that were never read again would otherwise accumulate forever;
+// GC is meant to be run periodically (see GCHandler) to sweep them up. It
+// fetches keys in a single keys-only query and deletes them GCBatchSize at a
+// time, stopping early if ctx's deadline is close so a single invocation
+// can't blow through a request deadline.
+func (cs *CascadeStore) GC(ctx context.Context) (deleted int, err error) {
+    q := datastore.NewQuery("Session").Filter("ExpiresAt <", time.Now()).KeysOnly()
+    keys, err := q.GetAll(ctx, nil)
+    if err != nil {
+        return deleted, err
+    }
+
+    return deleteKeysInBatches(ctx, keys, cs.GCBatchSize(), datastore.DeleteMulti)
+}
+
+// deleteKeysInBatches deletes keys batchSize at a time via deleteMulti,
+// stopping early if ctx's deadline is close so a single GC invocation can't
+// blow through a request deadline. Split out from GC so the batching/
+// deadline logic is testable without a live Datastore query.
+func deleteKeysInBatches(ctx context.Context, keys []*datastore.Key, batchSize int, deleteMulti func(context.Context, []*datastore.Key) error) (deleted int, err error) {
+    for len(keys) > 0 {
+        if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < time.Second {
+            return deleted, ctx.Err()
+        }
+
+        n := batchSize
+        if n > len(keys) {
+            n = len(keys)
+        }
+
+        if err := deleteMulti(ctx, keys[:n]); err != nil {
+            return deleted, err
+        }
+
+        deleted += n
+        keys = keys[n:]
+    }
+
+    return deleted, nil
+}
+
+// GCHandler runs GC and is meant to be mounted behind App Engine cron
+// (configure cron.yaml to hit GCPath). It requires the X-Appengine-Cron
+// header App Engine sets on cron-triggered requests, rejecting anything
+// else with 403 so the sweep can't be triggered by an arbitrary request.
+func (cs *CascadeStore) GCHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx := appengine.NewContext(r)
+
+        if r.Header.Get("X-Appengine-Cron") != "true" {
+            log.Warningf(ctx, "Rejecting GC request without X-Appengine-Cron header")
+            http.Error(w, NotCronError.Error(), http.StatusForbidden)
+            return
+        }
+
+        deleted, err := cs.GC(ctx)
+        if err != nil {
+            log.Errorf(ctx, "GC failed after deleting %d sessions: %s", deleted, err)
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+
+        log.Infof(ctx, "GC deleted %d expired sessions", deleted)
+        w.WriteHeader(http.StatusOK)
+    })
+}
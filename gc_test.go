@@ -0,0 +1,98 @@
+package cascadestore
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "google.golang.org/appengine/datastore"
+)
+
+func TestDeleteKeysInBatchesSplitsIntoBatchSize(t *testing.T) {
+    keys := make([]*datastore.Key, 7)
+
+    var gotBatchSizes []int
+    deleteMulti := func(ctx context.Context, batch []*datastore.Key) error {
+        gotBatchSizes = append(gotBatchSizes, len(batch))
+        return nil
+    }
+
+    deleted, err := deleteKeysInBatches(context.Background(), keys, 3, deleteMulti)
+    if err != nil {
+        t.Fatalf("deleteKeysInBatches: %s", err)
+    }
+    if deleted != 7 {
+        t.Fatalf("deleted = %d, want 7", deleted)
+    }
+
+    want := []int{3, 3, 1}
+    if len(gotBatchSizes) != len(want) {
+        t.Fatalf("batch sizes = %v, want %v", gotBatchSizes, want)
+    }
+    for i := range want {
+        if gotBatchSizes[i] != want[i] {
+            t.Fatalf("batch sizes = %v, want %v", gotBatchSizes, want)
+        }
+    }
+}
+
+func TestDeleteKeysInBatchesStopsBeforeDeadline(t *testing.T) {
+    keys := make([]*datastore.Key, 10)
+
+    calls := 0
+    deleteMulti := func(ctx context.Context, batch []*datastore.Key) error {
+        calls++
+        return nil
+    }
+
+    // A deadline that has already passed (and so is also under a second
+    // away) should make deleteKeysInBatches bail out before even
+    // attempting the first batch.
+    ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+    defer cancel()
+    time.Sleep(10 * time.Millisecond)
+
+    deleted, err := deleteKeysInBatches(ctx, keys, 3, deleteMulti)
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+    }
+    if deleted != 0 {
+        t.Fatalf("deleted = %d, want 0", deleted)
+    }
+    if calls != 0 {
+        t.Fatalf("deleteMulti called %d times, want 0", calls)
+    }
+}
+
+func TestDeleteKeysInBatchesPropagatesDeleteError(t *testing.T) {
+    keys := make([]*datastore.Key, 5)
+    boom := errors.New("boom")
+
+    deleteMulti := func(ctx context.Context, batch []*datastore.Key) error {
+        return boom
+    }
+
+    deleted, err := deleteKeysInBatches(context.Background(), keys, 2, deleteMulti)
+    if !errors.Is(err, boom) {
+        t.Fatalf("err = %v, want %v", err, boom)
+    }
+    if deleted != 0 {
+        t.Fatalf("deleted = %d, want 0", deleted)
+    }
+}
+
+func TestDeleteKeysInBatchesEmpty(t *testing.T) {
+    deleteMulti := func(ctx context.Context, batch []*datastore.Key) error {
+        t.Fatal("deleteMulti should not be called for an empty key set")
+        return nil
+    }
+
+    deleted, err := deleteKeysInBatches(context.Background(), nil, 100, deleteMulti)
+    if err != nil {
+        t.Fatalf("deleteKeysInBatches: %s", err)
+    }
+    if deleted != 0 {
+        t.Fatalf("deleted = %d, want 0", deleted)
+    }
+}
@@ -0,0 +1,24 @@
+package cascadestore
+
+import (
+    "context"
+
+    "google.golang.org/appengine/log"
+)
+
+// debugf and warningf wrap appengine/log's Debugf/Warningf but tolerate a
+// ctx that wasn't built from a live App Engine request (e.g. a bare
+// context.Context in a test): appengine/log panics given such a context,
+// and the call sites that use these two helpers (loadBackends's coalescing,
+// touchBackend's sliding-expiration writes, and the RequestBackend
+// no-store warning) need to stay exercisable by tests without a real App
+// Engine environment.
+func debugf(ctx context.Context, format string, args ...interface{}) {
+    defer func() { recover() }()
+    log.Debugf(ctx, format, args...)
+}
+
+func warningf(ctx context.Context, format string, args ...interface{}) {
+    defer func() { recover() }()
+    log.Warningf(ctx, format, args...)
+}
@@ -0,0 +1,42 @@
+package cascadestore
+
+import (
+    "context"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+// RedisBackend stores sessions in Redis (or a Redis-compatible service like
+// Cloud Memorystore), using Redis's own key expiration for TTL rather than
+// tracking it ourselves the way datastoreBackend has to. It's meant for
+// running CascadeStore outside App Engine, e.g. on Cloud Run, where
+// Memcache/Datastore aren't available: pair it with NewCascadeStoreWithBackends
+// instead of the bitmask constructors.
+type RedisBackend struct {
+    Client *redis.Client
+}
+
+// NewRedisBackend wraps an already-configured *redis.Client as a Backend.
+func NewRedisBackend(client *redis.Client) *RedisBackend {
+    return &RedisBackend{Client: client}
+}
+
+func (b *RedisBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    value, err := b.Client.Get(ctx, key).Bytes()
+    if err != nil {
+        if err == redis.Nil {
+            return nil, false, nil
+        }
+        return nil, false, err
+    }
+    return value, true, nil
+}
+
+func (b *RedisBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    return b.Client.Set(ctx, key, value, ttl).Err()
+}
+
+func (b *RedisBackend) Delete(ctx context.Context, key string) error {
+    return b.Client.Del(ctx, key).Err()
+}
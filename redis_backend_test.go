@@ -0,0 +1,6 @@
+package cascadestore
+
+// RedisBackend needs a live Redis (or miniredis) server to exercise Get/Set/
+// Delete against, which isn't available in this package's test environment;
+// this just pins it to the Backend interface it's meant to satisfy.
+var _ Backend = (*RedisBackend)(nil)
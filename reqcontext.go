@@ -0,0 +1,96 @@
+// +build !legacy_gcontext
+
+package cascadestore
+
+import (
+    "context"
+    "net/http"
+    "sync"
+
+    "google.golang.org/appengine"
+)
+
+// requestContextKey is an unexported type so values CascadeStore stores in
+// a request's context.Context can't collide with keys set by other
+// packages (see the context.WithValue docs on key types).
+type requestContextKey struct{}
+
+// requestStore is the per-request cache for CascadeStore's RequestBackend.
+// It's installed as a single context.Context value (by Middleware or
+// WithSession) and mutated in place, since http.Request.Context() can't be
+// swapped out after the fact without handing callers a new *http.Request.
+type requestStore struct {
+    mu    sync.RWMutex
+    items map[string]requestItem
+}
+
+// Middleware returns an http.Handler that installs a request-scoped session
+// cache into r's context before calling next, replacing the old
+// gorilla/context-based registry (which the Gorilla ecosystem dropped from
+// sessions). Downstream handlers see the same cache CascadeStore's
+// RequestBackend reads and writes, without needing to call WithSession
+// themselves.
+func (cs *CascadeStore) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        next.ServeHTTP(w, cs.WithSession(r))
+    })
+}
+
+// WithSession returns r, or a shallow copy of it carrying a fresh
+// request-scoped session cache in its context if one isn't already present.
+// http.Request.WithContext returns a new *http.Request rather than mutating
+// the original, so handlers that build their own request (e.g. dispatching
+// a sub-request) and need RequestBackend caching to follow it must call
+// this explicitly instead of relying on Middleware.
+func (cs *CascadeStore) WithSession(r *http.Request) *http.Request {
+    if _, ok := r.Context().Value(requestContextKey{}).(*requestStore); ok {
+        return r
+    }
+    store := &requestStore{items: make(map[string]requestItem)}
+    return r.WithContext(context.WithValue(r.Context(), requestContextKey{}, store))
+}
+
+// warnNoRequestStore logs that RequestBackend found no requestStore
+// installed on r's context, so a caller who still passes RequestBackend in
+// their bitmask but never wired up Middleware or WithSession sees a
+// discoverable warning instead of a silent no-op cache.
+func warnNoRequestStore(r *http.Request) {
+    warningf(appengine.NewContext(r), "cascadestore: RequestBackend has no request-scoped store in this request's context; call cs.Middleware or cs.WithSession(r), or RequestBackend will silently no-op")
+}
+
+func getRequestItem(r *http.Request, key string) (requestItem, bool) {
+    store, ok := r.Context().Value(requestContextKey{}).(*requestStore)
+    if !ok {
+        warnNoRequestStore(r)
+        return requestItem{}, false
+    }
+
+    store.mu.RLock()
+    defer store.mu.RUnlock()
+    item, ok := store.items[key]
+    return item, ok
+}
+
+func setRequestItem(r *http.Request, key string, item requestItem) {
+    store, ok := r.Context().Value(requestContextKey{}).(*requestStore)
+    if !ok {
+        warnNoRequestStore(r)
+        return
+    }
+
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    store.items[key] = item
+}
+
+func deleteRequestItem(r *http.Request, key string) {
+    store, ok := r.Context().Value(requestContextKey{}).(*requestStore)
+    if !ok {
+        warnNoRequestStore(r)
+        return
+    }
+
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    delete(store.items, key)
+}
@@ -0,0 +1,37 @@
+// +build legacy_gcontext
+
+package cascadestore
+
+import (
+    "net/http"
+
+    gcontext "github.com/gorilla/context"
+)
+
+// Middleware is a no-op under legacy_gcontext: gorilla/context keys its
+// registry off *http.Request directly, so there's no per-request context
+// value to install up front.
+func (cs *CascadeStore) Middleware(next http.Handler) http.Handler {
+    return next
+}
+
+// WithSession is a no-op under legacy_gcontext for the same reason.
+func (cs *CascadeStore) WithSession(r *http.Request) *http.Request {
+    return r
+}
+
+func getRequestItem(r *http.Request, key string) (requestItem, bool) {
+    itemRaw := gcontext.Get(r, key)
+    if itemRaw == nil {
+        return requestItem{}, false
+    }
+    return itemRaw.(requestItem), true
+}
+
+func setRequestItem(r *http.Request, key string, item requestItem) {
+    gcontext.Set(r, key, item)
+}
+
+func deleteRequestItem(r *http.Request, key string) {
+    gcontext.Delete(r, key)
+}
@@ -0,0 +1,75 @@
+package cascadestore
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestWithSessionInstallsStoreOnce(t *testing.T) {
+    cs := NewCascadeStoreWithBackends(nil)
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+    r1 := cs.WithSession(r)
+    if r1 == r {
+        t.Fatal("WithSession: expected a new *http.Request carrying the installed store")
+    }
+
+    // Calling it again on the already-wrapped request should be a no-op,
+    // returning the same *http.Request rather than installing a second
+    // store that would shadow the first.
+    r2 := cs.WithSession(r1)
+    if r2 != r1 {
+        t.Fatal("WithSession on an already-wrapped request returned a different *http.Request")
+    }
+}
+
+func TestRequestItemRoundTrip(t *testing.T) {
+    cs := NewCascadeStoreWithBackends(nil)
+    r := cs.WithSession(httptest.NewRequest(http.MethodGet, "/", nil))
+
+    setRequestItem(r, "session.a", requestItem{Value: []byte("hello")})
+
+    item, ok := getRequestItem(r, "session.a")
+    if !ok {
+        t.Fatal("getRequestItem: not found after setRequestItem")
+    }
+    if string(item.Value) != "hello" {
+        t.Fatalf("got %q, want %q", item.Value, "hello")
+    }
+
+    deleteRequestItem(r, "session.a")
+    if _, ok := getRequestItem(r, "session.a"); ok {
+        t.Fatal("getRequestItem: found after deleteRequestItem")
+    }
+}
+
+// TestRequestItemWithoutStoreIsNoop checks that getRequestItem/
+// setRequestItem/deleteRequestItem degrade to a harmless no-op (not a
+// panic) when WithSession/Middleware was never called, which is the
+// regression path warnNoRequestStore logs against.
+func TestRequestItemWithoutStoreIsNoop(t *testing.T) {
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+    setRequestItem(r, "session.a", requestItem{Value: []byte("hello")})
+    if _, ok := getRequestItem(r, "session.a"); ok {
+        t.Fatal("getRequestItem: found a value despite no request-scoped store ever being installed")
+    }
+    deleteRequestItem(r, "session.a") // must not panic
+}
+
+func TestMiddlewareInstallsSessionForNextHandler(t *testing.T) {
+    cs := NewCascadeStoreWithBackends(nil)
+
+    var sawItem bool
+    next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        setRequestItem(r, "session.a", requestItem{Value: []byte("hello")})
+        _, sawItem = getRequestItem(r, "session.a")
+    })
+
+    cs.Middleware(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+    if !sawItem {
+        t.Fatal("handler behind Middleware couldn't read back its own setRequestItem")
+    }
+}
@@ -0,0 +1,214 @@
+package cascadestore
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/gob"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+
+    "github.com/gorilla/sessions"
+)
+
+var (
+    // SecureValueTooShortError is returned when an encrypted payload is
+    // shorter than the version byte + nonce it must at least contain.
+    SecureValueTooShortError = errors.New("SessionSerializer: encrypted value is too short to contain a nonce")
+
+    // SecureNoMatchingKeyError is returned when none of the keys configured
+    // on a SecureSerializer can decrypt a payload.
+    SecureNoMatchingKeyError = errors.New("SessionSerializer: no key could decrypt the value")
+)
+
+// SessionSerializer provides an interface for serializing/deserializing a
+// session's Values to and from the byte slice that CascadeStore hands to its
+// backends. Implementations should mirror the behavior gorilla/sessions
+// stores (e.g. redistore) expect: Deserialize populates session.Values in
+// place rather than replacing it.
+type SessionSerializer interface {
+    Serialize(s *sessions.Session) ([]byte, error)
+    Deserialize(d []byte, s *sessions.Session) error
+}
+
+// GobSerializer uses encoding/gob. It's the default: fast and able to round
+// trip arbitrary Go values, at the cost of only being readable by Go.
+type GobSerializer struct{}
+
+func (s GobSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+    buf := new(bytes.Buffer)
+    enc := gob.NewEncoder(buf)
+    if err := enc.Encode(ss.Values); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func (s GobSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+    dec := gob.NewDecoder(bytes.NewBuffer(d))
+    return dec.Decode(&ss.Values)
+}
+
+// JSONSerializer uses encoding/json, so session values can be inspected or
+// edited outside the app (e.g. from the Datastore console) and read by
+// non-Go clients. Session keys must be strings, matching the Gorilla/
+// redistore convention; anything else is rejected at serialize time.
+type JSONSerializer struct{}
+
+func (s JSONSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+    m := make(map[string]interface{}, len(ss.Values))
+    for k, v := range ss.Values {
+        ks, ok := k.(string)
+        if !ok {
+            return nil, fmt.Errorf("SessionSerializer: non-string key %#v, cannot serialize session to JSON", k)
+        }
+        m[ks] = v
+    }
+    return json.Marshal(m)
+}
+
+func (s JSONSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+    m := make(map[string]interface{})
+    if err := json.Unmarshal(d, &m); err != nil {
+        return err
+    }
+    if ss.Values == nil {
+        ss.Values = make(map[interface{}]interface{}, len(m))
+    }
+    for k, v := range m {
+        ss.Values[k] = v
+    }
+    return nil
+}
+
+const secureSerializerVersionAESGCM byte = 1
+
+// SecureSerializer wraps another SessionSerializer and AES-GCM-encrypts the
+// serialized bytes before they reach Memcache/Datastore, so a reader of
+// those backends (or their backups) can't see session contents. Keys are
+// derived from the same securecookie key pairs CascadeStore already uses,
+// so there's nothing extra to provision.
+//
+// Serialized output is version-byte + 12-byte nonce + ciphertext, so keys
+// can be rotated: the first key is always used to encrypt, but every key is tried
+// on decrypt. While AllowUnencrypted is true, values that don't decrypt
+// under any key are handed to the inner serializer as-is, which lets a
+// rotation (or the initial enabling of encryption) read through old
+// plaintext entries until they naturally expire.
+type SecureSerializer struct {
+    inner            SessionSerializer
+    keys             [][]byte
+    AllowUnencrypted bool
+}
+
+// NewSecureSerializer derives one AES-256 key per securecookie key pair
+// (using the pair's block key, falling back to its hash key if no block key
+// was given) and wraps inner with them. keyPairs[0] is used to encrypt; all
+// of them are tried to decrypt, so rotation is a matter of prepending a new
+// pair and keeping the old one around until its sessions expire.
+func NewSecureSerializer(inner SessionSerializer, keyPairs [][]byte) (*SecureSerializer, error) {
+    if len(keyPairs) == 0 {
+        return nil, errors.New("SessionSerializer: at least one key pair is required")
+    }
+
+    keys := make([][]byte, 0, len(keyPairs)/2+1)
+    for i := 0; i < len(keyPairs); i += 2 {
+        secret := keyPairs[i]
+        if i+1 < len(keyPairs) && len(keyPairs[i+1]) > 0 {
+            secret = keyPairs[i+1]
+        }
+        derived := sha256.Sum256(secret)
+        keys = append(keys, derived[:])
+    }
+
+    return &SecureSerializer{inner: inner, keys: keys}, nil
+}
+
+func (s *SecureSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+    plaintext, err := s.inner.Serialize(ss)
+    if err != nil {
+        return nil, err
+    }
+
+    gcm, err := s.gcmForKey(s.keys[0])
+    if err != nil {
+        return nil, err
+    }
+
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+        return nil, err
+    }
+
+    out := make([]byte, 0, 1+len(nonce)+len(plaintext)+gcm.Overhead())
+    out = append(out, secureSerializerVersionAESGCM)
+    out = append(out, nonce...)
+    out = gcm.Seal(out, nonce, plaintext, nil)
+    return out, nil
+}
+
+func (s *SecureSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+    plaintext, err := s.decrypt(d)
+    if err != nil {
+        if s.AllowUnencrypted {
+            return s.inner.Deserialize(d, ss)
+        }
+        return err
+    }
+    return s.inner.Deserialize(plaintext, ss)
+}
+
+func (s *SecureSerializer) decrypt(d []byte) ([]byte, error) {
+    if len(d) == 0 || d[0] != secureSerializerVersionAESGCM {
+        return nil, SecureNoMatchingKeyError
+    }
+    d = d[1:]
+
+    for _, key := range s.keys {
+        gcm, err := s.gcmForKey(key)
+        if err != nil {
+            return nil, err
+        }
+        if len(d) < gcm.NonceSize() {
+            return nil, SecureValueTooShortError
+        }
+        nonce, ciphertext := d[:gcm.NonceSize()], d[gcm.NonceSize():]
+        if plaintext, err := gcm.Open(nil, nonce, ciphertext, nil); err == nil {
+            return plaintext, nil
+        }
+    }
+    return nil, SecureNoMatchingKeyError
+}
+
+func (s *SecureSerializer) gcmForKey(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+var serializerRegistry = map[string]SessionSerializer{
+    "gob":  GobSerializer{},
+    "json": JSONSerializer{},
+}
+
+// RegisterSerializer makes a SessionSerializer available by name, so it can
+// be selected via config (e.g. a "serializer: json" setting) instead of
+// calling SetSerializer directly. SecureSerializer isn't registered here
+// since it needs key pairs to construct; build it with NewSecureSerializer
+// and register the result under whatever name your config uses.
+func RegisterSerializer(name string, ss SessionSerializer) {
+    serializerRegistry[name] = ss
+}
+
+// SerializerByName looks up a serializer by the name it (or a built-in:
+// "gob", "json") was registered under.
+func SerializerByName(name string) (SessionSerializer, bool) {
+    ss, ok := serializerRegistry[name]
+    return ss, ok
+}
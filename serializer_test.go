@@ -0,0 +1,176 @@
+package cascadestore
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/gorilla/sessions"
+)
+
+func TestJSONSerializerRejectsNonStringKeys(t *testing.T) {
+    s := JSONSerializer{}
+    session := &sessions.Session{Values: map[interface{}]interface{}{42: "answer"}}
+
+    if _, err := s.Serialize(session); err == nil {
+        t.Fatal("Serialize with a non-string key: expected an error, got nil")
+    }
+}
+
+func TestJSONSerializerRoundTrip(t *testing.T) {
+    s := JSONSerializer{}
+    in := &sessions.Session{Values: map[interface{}]interface{}{"user_id": "abc123"}}
+
+    d, err := s.Serialize(in)
+    if err != nil {
+        t.Fatalf("Serialize: %s", err)
+    }
+
+    out := &sessions.Session{}
+    if err := s.Deserialize(d, out); err != nil {
+        t.Fatalf("Deserialize: %s", err)
+    }
+    if out.Values["user_id"] != "abc123" {
+        t.Fatalf("got %v, want %v", out.Values["user_id"], "abc123")
+    }
+}
+
+func TestGobSerializerRoundTrip(t *testing.T) {
+    s := GobSerializer{}
+    in := &sessions.Session{Values: map[interface{}]interface{}{"user_id": "abc123"}}
+
+    d, err := s.Serialize(in)
+    if err != nil {
+        t.Fatalf("Serialize: %s", err)
+    }
+
+    out := &sessions.Session{Values: map[interface{}]interface{}{}}
+    if err := s.Deserialize(d, out); err != nil {
+        t.Fatalf("Deserialize: %s", err)
+    }
+    if out.Values["user_id"] != "abc123" {
+        t.Fatalf("got %v, want %v", out.Values["user_id"], "abc123")
+    }
+}
+
+func TestSecureSerializerRoundTrip(t *testing.T) {
+    inner := GobSerializer{}
+    key := make([]byte, 32)
+    ss, err := NewSecureSerializer(inner, [][]byte{key})
+    if err != nil {
+        t.Fatalf("NewSecureSerializer: %s", err)
+    }
+
+    in := &sessions.Session{Values: map[interface{}]interface{}{"user_id": "abc123"}}
+    d, err := ss.Serialize(in)
+    if err != nil {
+        t.Fatalf("Serialize: %s", err)
+    }
+
+    out := &sessions.Session{Values: map[interface{}]interface{}{}}
+    if err := ss.Deserialize(d, out); err != nil {
+        t.Fatalf("Deserialize: %s", err)
+    }
+    if out.Values["user_id"] != "abc123" {
+        t.Fatalf("got %v, want %v", out.Values["user_id"], "abc123")
+    }
+}
+
+// TestSecureSerializerRotation checks that a value encrypted under an older
+// key is still readable once a new key pair is prepended, as the rotation
+// doc comment on SecureSerializer promises.
+func TestSecureSerializerRotation(t *testing.T) {
+    inner := GobSerializer{}
+    oldKey := make([]byte, 32)
+    oldKey[0] = 1
+    newKey := make([]byte, 32)
+    newKey[0] = 2
+
+    before, err := NewSecureSerializer(inner, [][]byte{oldKey})
+    if err != nil {
+        t.Fatalf("NewSecureSerializer(before): %s", err)
+    }
+
+    in := &sessions.Session{Values: map[interface{}]interface{}{"user_id": "abc123"}}
+    d, err := before.Serialize(in)
+    if err != nil {
+        t.Fatalf("Serialize: %s", err)
+    }
+
+    // Rotated: newKey's pair is now first (used to encrypt), but oldKey's
+    // pair is still present so values encrypted before rotation still
+    // decrypt. Key pairs are hash/block pairs; nil block keys make each
+    // pair's key derive from its hash key.
+    after, err := NewSecureSerializer(inner, [][]byte{newKey, nil, oldKey, nil})
+    if err != nil {
+        t.Fatalf("NewSecureSerializer(after): %s", err)
+    }
+
+    out := &sessions.Session{Values: map[interface{}]interface{}{}}
+    if err := after.Deserialize(d, out); err != nil {
+        t.Fatalf("Deserialize after rotation: %s", err)
+    }
+    if out.Values["user_id"] != "abc123" {
+        t.Fatalf("got %v, want %v", out.Values["user_id"], "abc123")
+    }
+}
+
+// TestSecureSerializerAllowUnencryptedFallback checks that, with
+// AllowUnencrypted set, a value that doesn't decrypt under any configured
+// key (e.g. plaintext written before encryption was enabled) is handed to
+// the inner serializer as-is instead of erroring.
+func TestSecureSerializerAllowUnencryptedFallback(t *testing.T) {
+    inner := GobSerializer{}
+    key := make([]byte, 32)
+    ss, err := NewSecureSerializer(inner, [][]byte{key})
+    if err != nil {
+        t.Fatalf("NewSecureSerializer: %s", err)
+    }
+    ss.AllowUnencrypted = true
+
+    plainIn := &sessions.Session{Values: map[interface{}]interface{}{"user_id": "abc123"}}
+    plaintext, err := inner.Serialize(plainIn)
+    if err != nil {
+        t.Fatalf("inner.Serialize: %s", err)
+    }
+
+    out := &sessions.Session{Values: map[interface{}]interface{}{}}
+    if err := ss.Deserialize(plaintext, out); err != nil {
+        t.Fatalf("Deserialize with AllowUnencrypted: %s", err)
+    }
+    if out.Values["user_id"] != "abc123" {
+        t.Fatalf("got %v, want %v", out.Values["user_id"], "abc123")
+    }
+}
+
+func TestSecureSerializerRejectsUnknownKeyWithoutAllowUnencrypted(t *testing.T) {
+    inner := GobSerializer{}
+    key := make([]byte, 32)
+    ss, err := NewSecureSerializer(inner, [][]byte{key})
+    if err != nil {
+        t.Fatalf("NewSecureSerializer: %s", err)
+    }
+
+    plainIn := &sessions.Session{Values: map[interface{}]interface{}{"user_id": "abc123"}}
+    plaintext, err := inner.Serialize(plainIn)
+    if err != nil {
+        t.Fatalf("inner.Serialize: %s", err)
+    }
+
+    out := &sessions.Session{Values: map[interface{}]interface{}{}}
+    err = ss.Deserialize(plaintext, out)
+    if !errors.Is(err, SecureNoMatchingKeyError) {
+        t.Fatalf("got err %v, want %v", err, SecureNoMatchingKeyError)
+    }
+}
+
+func TestSerializerByName(t *testing.T) {
+    if _, ok := SerializerByName("gob"); !ok {
+        t.Error(`SerializerByName("gob"): not found`)
+    }
+    if _, ok := SerializerByName("json"); !ok {
+        t.Error(`SerializerByName("json"): not found`)
+    }
+    if _, ok := SerializerByName("nonexistent"); ok {
+        t.Error(`SerializerByName("nonexistent"): found, want not ok`)
+    }
+}
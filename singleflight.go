@@ -0,0 +1,88 @@
+package cascadestore
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/sessions"
+)
+
+// backendFetchTimeout bounds the detached context loadBackends gives the
+// shared Do() call, so a leaked singleflight leader (e.g. every waiter's
+// own request context was already canceled) can't hang around forever
+// doing backend RPCs nobody is waiting on anymore.
+const backendFetchTimeout = 10 * time.Second
+
+// detachedContext carries ctx's values but neither its deadline nor its
+// cancellation, so a singleflight "leader" goroutine isn't at the mercy of
+// whichever concurrent caller happened to be chosen as leader: if that
+// caller's own request context is canceled, every other waiter coalesced
+// onto the same Do() call would otherwise see that same spurious error
+// even though their own requests are still healthy.
+type detachedContext struct {
+    parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}        { return nil }
+func (detachedContext) Err() error                   { return nil }
+func (c detachedContext) Value(key interface{}) interface{} {
+    return c.parent.Value(key)
+}
+
+// loadBackends fetches the raw serialized session from the given backends
+// in order, coalescing concurrent callers for the same key through
+// cs.loadGroup so that a burst of requests for one session (e.g. parallel
+// XHRs right after a cold start) only performs one set of backend reads.
+// It returns nil, nil on a clean miss.
+func (cs *CascadeStore) loadBackends(ctx context.Context, r *http.Request, session *sessions.Session, key string, backends []Backend) ([]byte, error) {
+    v, err, _ := cs.loadGroup.Do(key, func() (interface{}, error) {
+        // The function below runs under whichever caller's goroutine
+        // singleflight happens to pick as leader for key; detach its
+        // context so that caller returning/disconnecting doesn't abort
+        // the RPC for every other waiter coalesced onto this call.
+        fetchCtx, cancel := context.WithTimeout(detachedContext{parent: ctx}, backendFetchTimeout)
+        defer cancel()
+        return cs.fetchBackends(fetchCtx, r, session, key, backends)
+    })
+    if err != nil || v == nil {
+        return nil, err
+    }
+
+    // v's byte slice may be handed to other goroutines waiting on the same
+    // Do() call; return a copy so nobody can mutate a result another
+    // in-flight request is also using.
+    shared := v.([]byte)
+    if shared == nil {
+        return nil, nil
+    }
+    value := make([]byte, len(shared))
+    copy(value, shared)
+    return value, nil
+}
+
+func (cs *CascadeStore) fetchBackends(ctx context.Context, r *http.Request, session *sessions.Session, key string, backends []Backend) ([]byte, error) {
+    ttl := cs.sessionMaxAge(session)
+
+    for _, b := range backends {
+        value, found, err := b.Get(ctx, key)
+        if err != nil {
+            return nil, err
+        }
+        if !found {
+            debugf(ctx, "Could not find session in backend %T: [%s]", b, key)
+            continue
+        }
+
+        debugf(ctx, "Found session in backend %T: [%s]", b, key)
+
+        if cs.SlidingExpiration {
+            cs.touchBackend(ctx, b, key, value, ttl)
+        }
+
+        return value, nil
+    }
+
+    return nil, nil
+}
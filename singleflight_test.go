@@ -0,0 +1,104 @@
+package cascadestore
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gorilla/sessions"
+)
+
+// countingBackend is a fake Backend that counts calls to Get and blocks on
+// release, so a test can force a burst of concurrent callers to overlap on
+// the same key before any of them completes.
+type countingBackend struct {
+    gets    int32
+    release chan struct{}
+    value   []byte
+}
+
+func (b *countingBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    atomic.AddInt32(&b.gets, 1)
+    <-b.release
+    return b.value, true, nil
+}
+
+func (b *countingBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    return nil
+}
+
+func (b *countingBackend) Delete(ctx context.Context, key string) error {
+    return nil
+}
+
+// TestLoadBackendsCoalescesConcurrentReads checks that cs.loadBackends
+// collapses a burst of concurrent callers for the same key into a single
+// backend Get, instead of one Get per caller.
+func TestLoadBackendsCoalescesConcurrentReads(t *testing.T) {
+    backend := &countingBackend{release: make(chan struct{}), value: []byte("session-value")}
+    cs := NewCascadeStoreWithBackends([]Backend{backend})
+
+    const concurrency = 50
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+    session := sessions.NewSession(cs, "test")
+    session.Options = &sessions.Options{MaxAge: 60}
+
+    var wg sync.WaitGroup
+    errs := make([]error, concurrency)
+    values := make([][]byte, concurrency)
+    for i := 0; i < concurrency; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            values[i], errs[i] = cs.loadBackends(context.Background(), r, session, "session.test", []Backend{backend})
+        }(i)
+    }
+
+    // Give every goroutine a chance to reach countingBackend.Get and queue
+    // up behind cs.loadGroup before letting any of them finish.
+    time.Sleep(50 * time.Millisecond)
+    close(backend.release)
+    wg.Wait()
+
+    for i, err := range errs {
+        if err != nil {
+            t.Fatalf("loadBackends[%d]: unexpected error: %s", i, err)
+        }
+        if string(values[i]) != "session-value" {
+            t.Fatalf("loadBackends[%d]: got %q, want %q", i, values[i], "session-value")
+        }
+    }
+
+    if got := atomic.LoadInt32(&backend.gets); got != 1 {
+        t.Errorf("backend.Get called %d times for %d concurrent callers sharing a key, want 1", got, concurrency)
+    }
+}
+
+// BenchmarkLoadBackendsConcurrentSameKey reports the ratio of backend RPCs
+// to loadBackends calls when many goroutines hammer the same session key,
+// demonstrating that singleflight coalescing keeps the RPC count flat
+// rather than scaling with concurrency.
+func BenchmarkLoadBackendsConcurrentSameKey(b *testing.B) {
+    backend := &countingBackend{release: make(chan struct{})}
+    close(backend.release) // nothing to gate here; the benchmark just counts RPCs under GOMAXPROCS-wide parallelism
+    cs := NewCascadeStoreWithBackends([]Backend{backend})
+
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+    session := sessions.NewSession(cs, "test")
+    session.Options = &sessions.Options{MaxAge: 60}
+
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            if _, err := cs.loadBackends(context.Background(), r, session, "session.test", []Backend{backend}); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+
+    b.ReportMetric(float64(atomic.LoadInt32(&backend.gets)), "backend_gets")
+}
@@ -11,12 +11,10 @@ import (
 
     "google.golang.org/appengine"
     "google.golang.org/appengine/log"
-    "google.golang.org/appengine/memcache"
-    "google.golang.org/appengine/datastore"
 
     "github.com/gorilla/securecookie"
     "github.com/gorilla/sessions"
-    gcontext "github.com/gorilla/context"
+    "golang.org/x/sync/singleflight"
 )
 
 var (
@@ -27,26 +25,41 @@ const (
     RequestBackend = 1 << iota
     MemcacheBackend = 1 << iota
     DatastoreBackend = 1 << iota
+    // DatastoreWriteBehindBackend enqueues the Datastore write on a
+    // taskqueue instead of writing it inline, trading a little durability
+    // (a dead queue before the task runs loses the write) for a much
+    // faster Save(). It only covers Datastore: reads in the window before
+    // the queued write lands won't see it unless MemcacheBackend is also
+    // configured (see WriteBehindBackends). See writebehind.go.
+    DatastoreWriteBehindBackend = 1 << iota
 )
 
 const (
-    // In most cases we won't want to use the "request" backend. Though it's 
-    // nice to prevent hitting Memcache or Datastore if the information is 
-    // requested multiple times during a single request, it won't be updated by 
-    // concurrent requests from the same user/browser. The distributed backends 
-    // will receive the updates but the "Request" backend will preempt it with 
-    // potentially old information. We'd have to implement a secondary channel, 
-    // like the Channel API, to receive fault notifications from other requests 
-    // that do an update so that we can know to update the information in the 
-    // request. 
+    // In most cases we won't want to use the "request" backend. Though it's
+    // nice to prevent hitting Memcache or Datastore if the information is
+    // requested multiple times during a single request, it won't be updated by
+    // concurrent requests from the same user/browser. The distributed backends
+    // will receive the updates but the "Request" backend will preempt it with
+    // potentially old information. We'd have to implement a secondary channel,
+    // like the Channel API, to receive fault notifications from other requests
+    // that do an update so that we can know to update the information in the
+    // request.
     DistributedBackends = MemcacheBackend | DatastoreBackend
     AllBackends = RequestBackend | MemcacheBackend | DatastoreBackend
+    // WriteBehindBackends pairs DatastoreWriteBehindBackend with a
+    // synchronous MemcacheBackend, so reads hit Memcache immediately
+    // instead of missing until the queued Datastore write lands.
+    WriteBehindBackends = MemcacheBackend | DatastoreWriteBehindBackend
 
     // Amount of time for cookies/redis keys to expire.
     DefaultExpireSeconds = 86400 * 30
     MaxValueLength = 4096
     DefaultMaxAgeSeconds = 60 * 20
     DefaultKeyPrefix = "session."
+
+    // Name of the push queue DatastoreWriteBehindBackend enqueues to when
+    // SetQueueName hasn't been called.
+    DefaultQueueName = "default"
 )
 
 // For datastore.
@@ -61,22 +74,46 @@ type requestItem struct {
 }
 
 type CascadeStore struct {
-    backendTypes  int
+    backendTypes  int // the bitmask NewCascadeStore was built from; not consulted internally, kept for callers that inspect it
+    backends      []Backend
     maxLength     int
     keyPrefix     string
     serializer    SessionSerializer
+    queueName     string
+    gcBatchSize   int
+    loadGroup     singleflight.Group
+    pendingWrites *pendingWrites // set when a DatastoreWriteBehindBackend is configured; see Flush in writebehind.go
 
     Codecs        []securecookie.Codec
     Options       *sessions.Options // default configuration
     DefaultMaxAge int               // default Redis TTL for a MaxAge == 0 session
+
+    // SlidingExpiration, when true, makes load() extend a session's TTL on
+    // every successful read instead of only on Save. See Touch.
+    SlidingExpiration bool
+    // TouchThreshold is how much remaining TTL a backend entry must have
+    // dropped below before a sliding-expiration read bothers writing an
+    // updated expiration back, so idle-but-frequently-read sessions don't
+    // incur a write on every single request. Backends that can't report
+    // remaining TTL (see remainingTTLer) are always touched. Zero means
+    // half of DefaultMaxAge.
+    TouchThreshold time.Duration
 }
 
-func NewCascadeStore(backendTypes int, keyPairs ...[]byte) *CascadeStore {
-    return &CascadeStore{
-        backendTypes: backendTypes,
+// NewCascadeStoreWithBackends builds a CascadeStore from an explicit,
+// ordered cascade of backends instead of the RequestBackend/MemcacheBackend/
+// DatastoreBackend bitmask NewCascadeStore takes. This is how to mix in a
+// backend the bitmask doesn't know about, e.g. RedisBackend in place of
+// Memcache+Datastore when running outside App Engine (Cloud Run, GKE, ...)
+// against Cloud Memorystore. Reads try each backend in order and return the
+// first hit; writes and deletes go to all of them.
+func NewCascadeStoreWithBackends(backends []Backend, keyPairs ...[]byte) *CascadeStore {
+    cs := &CascadeStore{
+        backends: backends,
         maxLength: MaxValueLength,
         keyPrefix: DefaultKeyPrefix,
         serializer: GobSerializer{},
+        queueName: DefaultQueueName,
 
         Codecs: securecookie.CodecsFromPairs(keyPairs...),
         Options: &sessions.Options{
@@ -85,6 +122,29 @@ func NewCascadeStore(backendTypes int, keyPairs ...[]byte) *CascadeStore {
         },
         DefaultMaxAge: DefaultMaxAgeSeconds, // 20 minutes seems like a reasonable default
     }
+
+    for _, b := range backends {
+        if db, ok := b.(datastoreBackend); ok && db.pending != nil {
+            cs.pendingWrites = db.pending
+        }
+    }
+
+    return cs
+}
+
+func NewCascadeStore(backendTypes int, keyPairs ...[]byte) *CascadeStore {
+    cs := NewCascadeStoreWithBackends(backendsFromBitmask(backendTypes, DefaultQueueName), keyPairs...)
+    cs.backendTypes = backendTypes
+    return cs
+}
+
+// NewCascadeStoreWithSerializer is like NewCascadeStore but lets the caller
+// pick the SessionSerializer up front instead of calling SetSerializer
+// afterwards.
+func NewCascadeStoreWithSerializer(backendTypes int, serializer SessionSerializer, keyPairs ...[]byte) *CascadeStore {
+    cs := NewCascadeStore(backendTypes, keyPairs...)
+    cs.serializer = serializer
+    return cs
 }
 
 // SetMaxLength sets RediStore.maxLength if the `l` argument is greater or equal 0
@@ -104,6 +164,19 @@ func (cs *CascadeStore) SetKeyPrefix(p string) {
     cs.keyPrefix = p
 }
 
+// SetQueueName sets the push queue DatastoreWriteBehindBackend enqueues
+// Datastore writes to. It must already exist in queue.yaml. Default is
+// DefaultQueueName ("default").
+func (cs *CascadeStore) SetQueueName(name string) {
+    cs.queueName = name
+    for i, b := range cs.backends {
+        if db, ok := b.(datastoreBackend); ok && db.writeBehind {
+            db.queueName = name
+            cs.backends[i] = db
+        }
+    }
+}
+
 // SetSerializer sets the serializer
 func (cs *CascadeStore) SetSerializer(ss SessionSerializer) {
     cs.serializer = ss
@@ -190,9 +263,9 @@ func (cs *CascadeStore) Save(r *http.Request, w http.ResponseWriter, session *se
     return nil
 }
 
-// save stores the session in redis.
+// save stores the session in every configured backend.
 func (cs *CascadeStore) save(r *http.Request, session *sessions.Session) (err error) {
-    ctx := appengine.NewContext(r)
+    ctx := contextWithRequest(appengine.NewContext(r), r)
 
     defer func() {
         if r := recover(); r != nil {
@@ -212,49 +285,12 @@ func (cs *CascadeStore) save(r *http.Request, session *sessions.Session) (err er
         panic(ValueTooBigError)
     }
 
-    age := session.Options.MaxAge
-    if age == 0 {
-        age = cs.DefaultMaxAge
-    }
+    ttl := cs.sessionMaxAge(session)
 
-    expires := time.Second * time.Duration(age)
-    expiresAt := time.Now().Add(expires)
-
-    if (cs.backendTypes & RequestBackend) > 0 {
-        log.Debugf(ctx, "Writing session to Request: [%s]", key)
-
-        item := &requestItem{
-            Value: serialized,
-            ExpiresAt: expiresAt,
-        }
+    for _, b := range cs.backends {
+        log.Debugf(ctx, "Writing session to backend %T: [%s]", b, key)
 
-        gcontext.Set(r, key, item)
-    }
-
-    if (cs.backendTypes & MemcacheBackend) > 0 {
-        log.Debugf(ctx, "Writing session to Memcache: [%s]", key)
-
-        item := &memcache.Item{
-            Key: key,
-            Value: serialized,
-            Expiration: expires,
-        }
-
-        if err := memcache.Set(ctx, item); err != nil {
-            panic(err)
-        }
-    }
-
-    if (cs.backendTypes & DatastoreBackend) > 0 {
-        log.Debugf(ctx, "Writing session to Datastore: [%s]", key)
-
-        s := &sessionKind{
-            Value: serialized,
-            ExpiresAt: expiresAt,
-        }
-
-        k := datastore.NewKey(ctx, "Session", key, 0, nil)
-        if _, err := datastore.Put(ctx, k, s); err != nil {
+        if err := b.Set(ctx, key, serialized, ttl); err != nil {
             panic(err)
         }
     }
@@ -262,10 +298,10 @@ func (cs *CascadeStore) save(r *http.Request, session *sessions.Session) (err er
     return nil
 }
 
-// load reads the session from redis.
+// load reads the session back out of the first backend that has it.
 // returns true if there is a sessoin data in DB
 func (cs *CascadeStore) load(r *http.Request, session *sessions.Session) (success bool, err error) {
-    ctx := appengine.NewContext(r)
+    ctx := contextWithRequest(appengine.NewContext(r), r)
 
     defer func() {
         if r := recover(); r != nil {
@@ -279,58 +315,32 @@ func (cs *CascadeStore) load(r *http.Request, session *sessions.Session) (succes
     log.Debugf(ctx, "Loading session: [%s]", session.ID)
 
     key := cs.keyPrefix + session.ID
+    backends := cs.backends
     var value []byte
-    now := time.Now()
-
-    if value == nil && (cs.backendTypes & RequestBackend) > 0 {
-        // Try request.
-
-        itemRaw := gcontext.Get(r, key)
-        if itemRaw != nil {
-            item := itemRaw.(requestItem)
-            if now.Before(item.ExpiresAt) {
-                value = item.Value
-                log.Debugf(ctx, "Found session in request: [%s]", key)
-            } else {
-                gcontext.Delete(r, key)
-            }
-        }
-    }
-
-    if value == nil && (cs.backendTypes & MemcacheBackend) > 0 {
-        // Try memcache.
 
-        var item *memcache.Item
-        if item, err = memcache.Get(ctx, key); err != nil {
-            if err == memcache.ErrCacheMiss {
-                log.Debugf(ctx, "Could not find session in Memcache: [%s]", key)
-            } else {
+    // The request backend is request-local, so there's no point coalescing
+    // it through loadGroup the way we do the remote backends below; check
+    // it up front instead. By construction (see backendsFromBitmask) it's
+    // always first when present.
+    if len(backends) > 0 {
+        if rb, ok := backends[0].(requestBackend); ok {
+            v, found, err := rb.Get(ctx, key)
+            if err != nil {
                 panic(err)
             }
-        } else if err == nil {
-            value = item.Value
-            log.Debugf(ctx, "Found session in Memcache: [%s]", key)
+            if found {
+                value = v
+                log.Debugf(ctx, "Found session in backend %T: [%s]", rb, key)
+            }
+            backends = backends[1:]
         }
     }
 
-    if value == nil && (cs.backendTypes & DatastoreBackend) > 0 {
-        // Try datastore.
-
-        k := datastore.NewKey(ctx, "Session", key, 0, nil)
-        s := &sessionKind{}
-        if err := datastore.Get(ctx, k, s); err != nil {
-            if err == datastore.ErrNoSuchEntity {
-                log.Debugf(ctx, "Could not find session in Datastore: [%s]", key)
-            } else {
-                panic(err)
-            }
-        } else if err == nil {
-            if now.Before(s.ExpiresAt) {
-                value = s.Value
-                log.Debugf(ctx, "Found session in Datastore: [%s]", key)
-            } else if err := cs.delete(r, session); err != nil {
-                panic(err)
-            }
+    if value == nil && len(backends) > 0 {
+        // Remote backend reads for the same key are coalesced across
+        // concurrent requests by cs.loadGroup; see singleflight.go.
+        if value, err = cs.loadBackends(ctx, r, session, key, backends); err != nil {
+            panic(err)
         }
     }
 
@@ -345,9 +355,9 @@ func (cs *CascadeStore) load(r *http.Request, session *sessions.Session) (succes
     return success, nil
 }
 
-// delete removes keys from redis if MaxAge<0
+// delete removes the session from every configured backend.
 func (cs *CascadeStore) delete(r *http.Request, session *sessions.Session) (err error) {
-    ctx := appengine.NewContext(r)
+    ctx := contextWithRequest(appengine.NewContext(r), r)
 
     defer func() {
         if r := recover(); r != nil {
@@ -360,29 +370,11 @@ func (cs *CascadeStore) delete(r *http.Request, session *sessions.Session) (err
 
     key := cs.keyPrefix + session.ID
 
-    if (cs.backendTypes & RequestBackend) > 0 {
-        log.Debugf(ctx, "Removing session from Request: [%s]", key)
-        gcontext.Delete(r, key)
-    }
-
-    if (cs.backendTypes & MemcacheBackend) > 0 {
-        log.Debugf(ctx, "Removing session from Memcache: [%s]", key)
-
-        if err := memcache.Delete(ctx, key); err != nil {
-            if err == memcache.ErrCacheMiss {
-                log.Warningf(ctx, "Tried and failed to remove old session from Memcache: [%s]", key)
-            } else {
-                panic(err)
-            }
-        }
-    }
-
-    if (cs.backendTypes & DatastoreBackend) > 0 {
-        log.Debugf(ctx, "Removing session from Datastore: [%s]", key)
+    for _, b := range cs.backends {
+        log.Debugf(ctx, "Removing session from backend %T: [%s]", b, key)
 
-        k := datastore.NewKey(ctx, "Session", key, 0, nil)
-        if err := datastore.Delete(ctx, k); err != nil {
-            log.Warningf(ctx, "Tried and failed to remove old session from Datastore: [%s]", key)
+        if err := b.Delete(ctx, key); err != nil {
+            log.Warningf(ctx, "Tried and failed to remove old session from backend %T: [%s]: %s", b, key, err)
         }
     }
 
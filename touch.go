@@ -0,0 +1,74 @@
+package cascadestore
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "google.golang.org/appengine"
+
+    "github.com/gorilla/sessions"
+)
+
+// sessionMaxAge returns how long session's backend entries should live,
+// falling back to cs.DefaultMaxAge when the session didn't set its own
+// Options.MaxAge.
+func (cs *CascadeStore) sessionMaxAge(session *sessions.Session) time.Duration {
+    age := session.Options.MaxAge
+    if age == 0 {
+        age = cs.DefaultMaxAge
+    }
+    return time.Second * time.Duration(age)
+}
+
+// touchThreshold returns cs.TouchThreshold, or half of DefaultMaxAge if it
+// wasn't set.
+func (cs *CascadeStore) touchThreshold() time.Duration {
+    if cs.TouchThreshold > 0 {
+        return cs.TouchThreshold
+    }
+    return time.Duration(cs.DefaultMaxAge) * time.Second / 2
+}
+
+// Touch extends a session's expiration in every configured backend that
+// holds it without re-serializing it, unlike Save: each backend's stored
+// bytes are read back and written again with a fresh TTL. It's the explicit
+// counterpart to SlidingExpiration: call it from handlers that want to bump
+// a session's idle timeout (e.g. on explicit user activity) without
+// extending every single read.
+func (cs *CascadeStore) Touch(r *http.Request, session *sessions.Session) error {
+    ctx := contextWithRequest(appengine.NewContext(r), r)
+    key := cs.keyPrefix + session.ID
+    ttl := cs.sessionMaxAge(session)
+
+    for _, b := range cs.backends {
+        value, found, err := b.Get(ctx, key)
+        if err != nil {
+            return err
+        }
+        if !found {
+            continue
+        }
+        if err := b.Set(ctx, key, value, ttl); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// touchBackend extends a hit's TTL in the backend it was found in for
+// SlidingExpiration, skipping the write if the backend can report it still
+// has plenty of TTL left (see remainingTTLer) — sliding expiration would
+// otherwise cost a write on every single read.
+func (cs *CascadeStore) touchBackend(ctx context.Context, b Backend, key string, value []byte, ttl time.Duration) {
+    if rt, ok := b.(remainingTTLer); ok {
+        if remaining, found, err := rt.RemainingTTL(ctx, key); err == nil && found && remaining >= cs.touchThreshold() {
+            return
+        }
+    }
+
+    if err := b.Set(ctx, key, value, ttl); err != nil {
+        warningf(ctx, "Could not extend sliding expiration in backend %T: [%s]: %s", b, key, err)
+    }
+}
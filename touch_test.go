@@ -0,0 +1,100 @@
+package cascadestore
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+// remainingTTLBackend is a fake Backend that also implements remainingTTLer,
+// so tests can control what touchBackend sees without a real backend RPC.
+type remainingTTLBackend struct {
+    remaining time.Duration
+    found     bool
+    sets      int
+}
+
+func (b *remainingTTLBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    return nil, false, nil
+}
+
+func (b *remainingTTLBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    b.sets++
+    return nil
+}
+
+func (b *remainingTTLBackend) Delete(ctx context.Context, key string) error {
+    return nil
+}
+
+func (b *remainingTTLBackend) RemainingTTL(ctx context.Context, key string) (time.Duration, bool, error) {
+    return b.remaining, b.found, nil
+}
+
+func TestTouchBackendSkipsWriteAboveThreshold(t *testing.T) {
+    cs := NewCascadeStoreWithBackends(nil)
+    cs.TouchThreshold = time.Minute
+
+    b := &remainingTTLBackend{remaining: 2 * time.Minute, found: true}
+    cs.touchBackend(context.Background(), b, "session.a", []byte("v"), 10*time.Minute)
+
+    if b.sets != 0 {
+        t.Fatalf("Set called %d times, want 0 (remaining TTL is above threshold)", b.sets)
+    }
+}
+
+func TestTouchBackendWritesBelowThreshold(t *testing.T) {
+    cs := NewCascadeStoreWithBackends(nil)
+    cs.TouchThreshold = time.Minute
+
+    b := &remainingTTLBackend{remaining: 30 * time.Second, found: true}
+    cs.touchBackend(context.Background(), b, "session.a", []byte("v"), 10*time.Minute)
+
+    if b.sets != 1 {
+        t.Fatalf("Set called %d times, want 1 (remaining TTL is below threshold)", b.sets)
+    }
+}
+
+// plainBackend is a fake Backend that doesn't implement remainingTTLer, to
+// check that touchBackend always writes when it can't ask a backend how
+// much TTL is left.
+type plainBackend struct {
+    sets int
+}
+
+func (b *plainBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    return nil, false, nil
+}
+
+func (b *plainBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    b.sets++
+    return nil
+}
+
+func (b *plainBackend) Delete(ctx context.Context, key string) error {
+    return nil
+}
+
+// TestTouchBackendAlwaysWritesWithoutRemainingTTLer checks that a backend
+// which can't report RemainingTTL (e.g. memcacheBackend) is always
+// touched, since there's no way to know it's
+// not needed.
+func TestTouchBackendAlwaysWritesWithoutRemainingTTLer(t *testing.T) {
+    cs := NewCascadeStoreWithBackends(nil)
+    b := &plainBackend{}
+
+    cs.touchBackend(context.Background(), b, "session.a", []byte("v"), 10*time.Minute)
+
+    if b.sets != 1 {
+        t.Fatalf("Set called %d times, want 1 (no RemainingTTL means always touch)", b.sets)
+    }
+}
+
+func TestTouchThresholdDefaultsToHalfMaxAge(t *testing.T) {
+    cs := NewCascadeStoreWithBackends(nil)
+    cs.DefaultMaxAge = 1000
+
+    if got, want := cs.touchThreshold(), 500*time.Second; got != want {
+        t.Fatalf("touchThreshold() = %s, want %s", got, want)
+    }
+}
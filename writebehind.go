@@ -0,0 +1,168 @@
+package cascadestore
+
+import (
+    "bytes"
+    "context"
+    "encoding/gob"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "sync"
+    "time"
+
+    "google.golang.org/appengine"
+    "google.golang.org/appengine/datastore"
+    "google.golang.org/appengine/log"
+    "google.golang.org/appengine/taskqueue"
+)
+
+// FlushPath is the internal handler path that decodes write-behind tasks
+// and performs the deferred datastore.Put. Mount CascadeStore.FlushHandler()
+// here.
+const FlushPath = "/_ah/cascadestore/flush"
+
+var (
+    // NotTaskQueueError is returned by FlushHandler when a request arrives
+    // without a header App Engine's task queue sets (and strips from any
+    // externally-originated request).
+    NotTaskQueueError = errors.New("cascadestore: request did not come from App Engine taskqueue")
+)
+
+// writeBehindPayload is the gob-encoded taskqueue task body enqueued by
+// save() for DatastoreWriteBehindBackend.
+type writeBehindPayload struct {
+    Key       string
+    Value     []byte
+    ExpiresAt time.Time
+}
+
+// pendingWrites tracks write-behind payloads that have been enqueued but
+// not yet confirmed applied by FlushHandler, keyed by session key, so
+// CascadeStore.Flush can drain and apply them directly instead of relying
+// on a taskqueue processor actually having run.
+type pendingWrites struct {
+    mu    sync.Mutex
+    items map[string]writeBehindPayload
+}
+
+func newPendingWrites() *pendingWrites {
+    return &pendingWrites{items: make(map[string]writeBehindPayload)}
+}
+
+func (p *pendingWrites) add(payload writeBehindPayload) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.items[payload.Key] = payload
+}
+
+// remove drops payload, but only if it's still the most recently recorded
+// write for its key, so FlushHandler applying an older task doesn't discard
+// a newer write-behind Set that raced in after the task was enqueued.
+func (p *pendingWrites) remove(payload writeBehindPayload) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if current, ok := p.items[payload.Key]; ok && current.ExpiresAt.Equal(payload.ExpiresAt) && bytes.Equal(current.Value, payload.Value) {
+        delete(p.items, payload.Key)
+    }
+}
+
+func (p *pendingWrites) drain() []writeBehindPayload {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    items := make([]writeBehindPayload, 0, len(p.items))
+    for _, payload := range p.items {
+        items = append(items, payload)
+    }
+    p.items = make(map[string]writeBehindPayload)
+    return items
+}
+
+func enqueueWriteBehind(ctx context.Context, queueName, key string, serialized []byte, expiresAt time.Time) error {
+    buf := new(bytes.Buffer)
+    if err := gob.NewEncoder(buf).Encode(writeBehindPayload{Key: key, Value: serialized, ExpiresAt: expiresAt}); err != nil {
+        return err
+    }
+
+    t := &taskqueue.Task{
+        Path:    FlushPath,
+        Payload: buf.Bytes(),
+    }
+    _, err := taskqueue.Add(ctx, t, queueName)
+    return err
+}
+
+// FlushHandler decodes a write-behind task enqueued by Save and performs the
+// deferred datastore.Put. Register it on FlushPath behind the queue named
+// by SetQueueName, e.g.:
+//
+//    http.Handle(cascadestore.FlushPath, cs.FlushHandler())
+//
+// App Engine strips the X-AppEngine-QueueName header from any request that
+// didn't come from its own taskqueue, so its presence is enough to reject
+// outside callers the same way GCHandler rejects requests without the cron
+// header: without this check, anyone who can reach FlushPath could forge an
+// arbitrary session's Datastore row.
+func (cs *CascadeStore) FlushHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx := appengine.NewContext(r)
+
+        if r.Header.Get("X-AppEngine-QueueName") == "" {
+            log.Warningf(ctx, "Rejecting flush request without X-AppEngine-QueueName header")
+            http.Error(w, NotTaskQueueError.Error(), http.StatusForbidden)
+            return
+        }
+
+        body, err := ioutil.ReadAll(r.Body)
+        if err != nil {
+            log.Errorf(ctx, "Could not read write-behind task body: %s", err)
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        var payload writeBehindPayload
+        if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&payload); err != nil {
+            log.Errorf(ctx, "Could not decode write-behind task: %s", err)
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        if err := putWriteBehind(ctx, payload); err != nil {
+            log.Errorf(ctx, "Could not flush write-behind session to Datastore: %s", err)
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+
+        if cs.pendingWrites != nil {
+            cs.pendingWrites.remove(payload)
+        }
+
+        w.WriteHeader(http.StatusOK)
+    })
+}
+
+func putWriteBehind(ctx context.Context, payload writeBehindPayload) error {
+    s := &sessionKind{Value: payload.Value, ExpiresAt: payload.ExpiresAt}
+    k := datastore.NewKey(ctx, "Session", payload.Key, 0, nil)
+    _, err := datastore.Put(ctx, k, s)
+    return err
+}
+
+// Flush drains and applies any write-behind payloads save() has enqueued but
+// FlushHandler hasn't yet confirmed, without waiting for the taskqueue to
+// run them. It's meant for tests, which typically don't run a queue
+// processor: call it after Save to make a write-behind session visible to a
+// synchronous Get immediately. In production the queue drains FlushHandler
+// on its own, so there's usually nothing left for Flush to do.
+func (cs *CascadeStore) Flush(ctx context.Context) error {
+    if cs.pendingWrites == nil {
+        return nil
+    }
+
+    for _, payload := range cs.pendingWrites.drain() {
+        if err := putWriteBehind(ctx, payload); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
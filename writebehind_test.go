@@ -0,0 +1,63 @@
+package cascadestore
+
+import (
+    "testing"
+    "time"
+)
+
+func TestPendingWritesAddDrain(t *testing.T) {
+    p := newPendingWrites()
+    p.add(writeBehindPayload{Key: "session.a", Value: []byte("1"), ExpiresAt: time.Now()})
+    p.add(writeBehindPayload{Key: "session.b", Value: []byte("2"), ExpiresAt: time.Now()})
+
+    drained := p.drain()
+    if len(drained) != 2 {
+        t.Fatalf("drain(): got %d payloads, want 2", len(drained))
+    }
+
+    // drain empties the set; a second drain should find nothing left.
+    if again := p.drain(); len(again) != 0 {
+        t.Fatalf("second drain(): got %d payloads, want 0", len(again))
+    }
+}
+
+func TestPendingWritesAddOverwritesSameKey(t *testing.T) {
+    p := newPendingWrites()
+    p.add(writeBehindPayload{Key: "session.a", Value: []byte("old"), ExpiresAt: time.Now()})
+    p.add(writeBehindPayload{Key: "session.a", Value: []byte("new"), ExpiresAt: time.Now()})
+
+    drained := p.drain()
+    if len(drained) != 1 {
+        t.Fatalf("drain(): got %d payloads, want 1", len(drained))
+    }
+    if string(drained[0].Value) != "new" {
+        t.Fatalf("got %q, want %q", drained[0].Value, "new")
+    }
+}
+
+func TestPendingWritesRemoveOnlyMatchingPayload(t *testing.T) {
+    p := newPendingWrites()
+    original := writeBehindPayload{Key: "session.a", Value: []byte("first"), ExpiresAt: time.Now()}
+    p.add(original)
+
+    // A newer write for the same key raced in after the original task was
+    // enqueued; FlushHandler processing the original task shouldn't drop it.
+    newer := writeBehindPayload{Key: "session.a", Value: []byte("second"), ExpiresAt: original.ExpiresAt.Add(time.Minute)}
+    p.add(newer)
+
+    p.remove(original)
+
+    drained := p.drain()
+    if len(drained) != 1 || string(drained[0].Value) != "second" {
+        t.Fatalf("got %v, want the newer payload to survive removal of the stale one", drained)
+    }
+}
+
+func TestPendingWritesRemoveUnknownKeyIsNoop(t *testing.T) {
+    p := newPendingWrites()
+    p.remove(writeBehindPayload{Key: "session.missing", Value: []byte("x"), ExpiresAt: time.Now()})
+
+    if drained := p.drain(); len(drained) != 0 {
+        t.Fatalf("drain(): got %d payloads, want 0", len(drained))
+    }
+}